@@ -0,0 +1,51 @@
+package config
+
+import "time"
+
+// Config 是应用的全局配置
+type Config struct {
+	// ModelBreakerWindowSeconds 模型熔断器滑动窗口的长度（秒），用于计算窗口内的错误率
+	ModelBreakerWindowSeconds int
+	// ModelBreakerCoolDownSeconds 熔断器打开后，进入半开状态前需要等待的冷却时间（秒）
+	ModelBreakerCoolDownSeconds int
+	// ModelBreakerConsecutiveFailures 触发熔断的连续失败次数阈值
+	ModelBreakerConsecutiveFailures int
+	// ModelBreakerErrorRate 触发熔断的滑动窗口错误率阈值（0~1）
+	ModelBreakerErrorRate float64
+
+	// GroupChatDailyFreeAllowance 群聊每用户每模型的每日免费调用次数全局默认值，
+	// 可在 user_daily_limits 表中按用户覆盖
+	GroupChatDailyFreeAllowance int64
+}
+
+// ModelBreakerWindow 返回熔断器滑动窗口的时长，未配置时使用默认值
+func (c *Config) ModelBreakerWindow() time.Duration {
+	if c.ModelBreakerWindowSeconds <= 0 {
+		return 60 * time.Second
+	}
+	return time.Duration(c.ModelBreakerWindowSeconds) * time.Second
+}
+
+// ModelBreakerCoolDown 返回熔断器打开后的冷却时长，未配置时使用默认值
+func (c *Config) ModelBreakerCoolDown() time.Duration {
+	if c.ModelBreakerCoolDownSeconds <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(c.ModelBreakerCoolDownSeconds) * time.Second
+}
+
+// ModelBreakerConsecutiveFailureThreshold 返回触发熔断的连续失败次数阈值，未配置时使用默认值
+func (c *Config) ModelBreakerConsecutiveFailureThreshold() int {
+	if c.ModelBreakerConsecutiveFailures <= 0 {
+		return 5
+	}
+	return c.ModelBreakerConsecutiveFailures
+}
+
+// ModelBreakerErrorRateThreshold 返回触发熔断的滑动窗口错误率阈值，未配置时使用默认值
+func (c *Config) ModelBreakerErrorRateThreshold() float64 {
+	if c.ModelBreakerErrorRate <= 0 {
+		return 0.5
+	}
+	return c.ModelBreakerErrorRate
+}
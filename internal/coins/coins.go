@@ -0,0 +1,13 @@
+package coins
+
+// CoinModel 描述一个模型按输入/输出 token 计费的单价（单位：每 1000 token 的智慧果数）
+type CoinModel struct {
+	ModelId          string
+	InputPricePer1K  int64
+	OutputPricePer1K int64
+}
+
+// GetTextModelCoins 根据模型单价与本次请求消耗的输入/输出 token 数，计算需要扣除的智慧果数量
+func GetTextModelCoins(model CoinModel, inputTokens, outputTokens int64) int64 {
+	return inputTokens*model.InputPricePer1K/1000 + outputTokens*model.OutputPricePer1K/1000
+}
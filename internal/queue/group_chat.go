@@ -3,6 +3,7 @@ package queue
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/mylxsw/aidea-server/pkg/ai/chat"
 	repo "github.com/mylxsw/aidea-server/pkg/repo"
@@ -13,9 +14,23 @@ import (
 	"github.com/mylxsw/aidea-server/config"
 	"github.com/mylxsw/aidea-server/internal/coins"
 	"github.com/mylxsw/asteria/log"
-	"github.com/mylxsw/go-utils/ternary"
+	"github.com/redis/go-redis/v9"
 )
 
+// groupChatStreamFlushInterval 控制流式响应写入数据库的最小间隔，避免频繁更新造成数据库压力
+const groupChatStreamFlushInterval = 500 * time.Millisecond
+
+// groupChatStreamSubscriberCheckInterval 控制轮询 SSE 订阅者数量的间隔。队列任务与
+// SSE 接口运行在两个不同的进程/goroutine 上，浏览器断开连接只会终止 StreamGroupChatMessage
+// 里的转发循环，queue worker 这边必须自己轮询 Redis Pub/Sub 的订阅者数量才能感知到
+// 客户端已经离开，进而取消仍在进行的上游调用，避免为一个没人看的请求持续消耗算力
+const groupChatStreamSubscriberCheckInterval = 5 * time.Second
+
+// groupChatStreamChannel 返回指定消息的 Redis Pub/Sub 频道名称
+func groupChatStreamChannel(groupID, messageID int64) string {
+	return fmt.Sprintf("group-chat:%d:%d", groupID, messageID)
+}
+
 type GroupChatPayload struct {
 	ID              string        `json:"id,omitempty"`
 	GroupID         int64         `json:"group_id,omitempty"`
@@ -27,6 +42,24 @@ type GroupChatPayload struct {
 	ContextMessages chat.Messages `json:"context_messages,omitempty"`
 	CreatedAt       time.Time     `json:"created_at,omitempty"`
 	FreezedCoins    int64         `json:"freezed_coins,omitempty"`
+	// Stream 标记该任务是否需要以流式方式返回结果，开启后增量内容会通过
+	// Redis Pub/Sub 发布，供 SSE 接口转发给前端
+	Stream bool `json:"stream,omitempty"`
+	// BatchID 关联的批次 ID，由 BuildGroupChatBatchHandler fan-out 出的子任务携带，
+	// 用于汇报完成状态给协调任务，单个任务直接发起时为空
+	BatchID string `json:"batch_id,omitempty"`
+	// IdempotencyKey 用于在前端重试提交时去重，未显式指定时由
+	// (UserID, GroupID, QuestionID, MessageID) 派生，参见 groupChatIdempotencyKey
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// groupChatIdempotencyKey 返回该任务用于入队去重的幂等键，优先使用调用方显式传入的
+// IdempotencyKey，否则由 (UserID, GroupID, QuestionID, MessageID) 派生
+func groupChatIdempotencyKey(payload GroupChatPayload) string {
+	if payload.IdempotencyKey != "" {
+		return payload.IdempotencyKey
+	}
+	return fmt.Sprintf("group-chat:idempotency:%d:%d:%d:%d", payload.UserID, payload.GroupID, payload.QuestionID, payload.MessageID)
 }
 
 func (payload *GroupChatPayload) GetTitle() string {
@@ -58,7 +91,50 @@ func NewGroupChatTask(payload any) *asynq.Task {
 	return asynq.NewTask(TypeGroupChat, data)
 }
 
-func BuildGroupChatHandler(conf *config.Config, ct chat.Chat, rep *repo.Repository, svc *service.Service) TaskHandler {
+// ErrGroupChatTaskEnqueueInFlight 表示该幂等键已经被另一次调用抢先 SETNX，原始入队
+// 还没有来得及把任务 ID 写回 Redis。这只是一个短暂的并发窗口，而不是真正的入队失败：
+// 原始调用大概率会成功，调用方不应该把这次返回的 error 当成该消息彻底失败处理
+var ErrGroupChatTaskEnqueueInFlight = errors.New("duplicate group chat task is still being enqueued")
+
+// EnqueueGroupChatTask 以幂等的方式将群聊任务入队：在真正入队之前先用幂等键（见
+// groupChatIdempotencyKey）对 Redis 做 SETNX，当同一个幂等键在 24 小时内重复提交时，
+// 直接返回首次入队得到的任务 ID，而不会重复创建任务，避免前端断网重传导致的重复计费
+func EnqueueGroupChatTask(ctx context.Context, client *asynq.Client, rdb *redis.Client, payload GroupChatPayload) (string, error) {
+	key := fmt.Sprintf("group-chat:enqueue:%s", groupChatIdempotencyKey(payload))
+
+	ok, err := rdb.SetNX(ctx, key, "", 24*time.Hour).Result()
+	if err != nil {
+		return "", fmt.Errorf("set idempotency key failed: %w", err)
+	}
+	if !ok {
+		existingID, err := rdb.Get(ctx, key).Result()
+		if err != nil {
+			return "", fmt.Errorf("load original idempotency task id failed: %w", err)
+		}
+		if existingID != "" {
+			return existingID, nil
+		}
+		// 幂等键存在但原始任务尚未写回任务 ID（并发窗口内），让调用方按原有方式重试
+		return "", ErrGroupChatTaskEnqueueInFlight
+	}
+
+	info, err := client.EnqueueContext(ctx, NewGroupChatTask(payload))
+	if err != nil {
+		rdb.Del(ctx, key)
+		return "", fmt.Errorf("enqueue group chat task failed: %w", err)
+	}
+
+	if err := rdb.Set(ctx, key, info.ID, 24*time.Hour).Err(); err != nil {
+		// 写回失败的话，幂等键会一直留着一个空值挡住后续 24 小时内的所有重试，
+		// 不如直接删除它，让重试按“未提交过”处理，顶多偶发重复入队
+		log.With(payload).Warningf("persist idempotency task id failed, dropping key: %s", err)
+		rdb.Del(ctx, key)
+	}
+
+	return info.ID, nil
+}
+
+func BuildGroupChatHandler(conf *config.Config, ct chat.Chat, rep *repo.Repository, svc *service.Service, queueClient *asynq.Client, rdb *redis.Client) TaskHandler {
 	return func(ctx context.Context, task *asynq.Task) (err error) {
 		var payload GroupChatPayload
 		if err := json.Unmarshal(task.Payload(), &payload); err != nil {
@@ -70,6 +146,10 @@ func BuildGroupChatHandler(conf *config.Config, ct chat.Chat, rep *repo.Reposito
 			return nil
 		}
 
+		// committed 标记消息更新 + 配额扣减 + 队列状态更新的事务是否已经成功提交，
+		// 用于决定 panic 恢复时是否需要退还冻结的智慧果
+		var committed bool
+
 		defer func() {
 			if err2 := recover(); err2 != nil {
 				log.With(task).Errorf("panic: %v", err2)
@@ -99,12 +179,19 @@ func BuildGroupChatHandler(conf *config.Config, ct chat.Chat, rep *repo.Reposito
 				}
 			}
 
-			// 无论如何，都要释放用户被冻结的智慧果
-			if payload.FreezedCoins > 0 {
+			// 只有在消息更新 + 配额扣减事务没有提交的情况下才退还冻结额度：一旦事务
+			// 提交，冻结的预占就已经转化为事务内 QuotaConsume 记录的真实扣费，此时再
+			// 退还会让用户凭空拿回本该扣除的智慧果
+			if payload.FreezedCoins > 0 && !committed {
 				if err := svc.User.UnfreezeUserQuota(ctx, payload.UserID, payload.FreezedCoins); err != nil {
 					log.F(log.M{"payload": payload}).Errorf("群聊任务执行失败，释放用户冻结的智慧果失败: %s", err)
 				}
 			}
+
+			// 如果任务隶属于某个批次，向协调任务上报完成状态
+			if payload.BatchID != "" {
+				reportGroupChatBatchMemberDone(context.TODO(), rep, svc, queueClient, rdb, payload, err)
+			}
 		}()
 
 		mod := svc.Chat.Model(ctx, payload.ModelID)
@@ -112,6 +199,26 @@ func BuildGroupChatHandler(conf *config.Config, ct chat.Chat, rep *repo.Reposito
 			panic(fmt.Errorf("model %s not found or disabled", payload.ModelID))
 		}
 
+		// 任务被 worker 取出、真正开始生成回复，如果隶属于某个批次，把该成员的状态从
+		// pending 推进到 running，使轮询批次状态的客户端能区分"排队中"与"生成中"
+		if payload.BatchID != "" {
+			if err := rep.ChatGroupBatch.MarkMemberRunning(ctx, payload.BatchID, payload.MemberID); err != nil {
+				log.With(payload).Errorf("mark chat group batch member running failed: %s", err)
+			}
+		}
+
+		// 模型熔断 + 自动故障转移：如果目标模型的熔断器处于打开状态，按配置的故障转移链
+		// 依次尝试下一个健康的替代模型
+		actualModelID, fallbackReason, err := selectHealthyModel(ctx, rdb, conf, svc, mod.ModelId)
+		if err != nil {
+			panic(fmt.Errorf("select healthy model failed: %w", err))
+		}
+		if actualModelID != mod.ModelId {
+			if actualMod := svc.Chat.Model(ctx, actualModelID); actualMod != nil {
+				mod = actualMod
+			}
+		}
+
 		req, _, err := (chat.Request{
 			Model:    mod.ModelId,
 			Messages: payload.ContextMessages,
@@ -120,8 +227,14 @@ func BuildGroupChatHandler(conf *config.Config, ct chat.Chat, rep *repo.Reposito
 			panic(fmt.Errorf("fix chat request failed: %w", err))
 		}
 
-		// 调用 AI 系统
-		resp, err := ct.Chat(ctx, *req)
+		// 调用 AI 系统，Stream 模式下增量内容会被发布到 Redis，供 SSE 接口订阅转发
+		var resp chat.Response
+		if payload.Stream {
+			resp, err = streamGroupChatAndPublish(ctx, rdb, ct, rep, payload, *req)
+		} else {
+			resp, err = ct.Chat(ctx, *req)
+		}
+		recordModelBreakerResult(ctx, rdb, conf, mod.ModelId, err == nil && resp.ErrorCode == "")
 		if err != nil {
 			panic(fmt.Errorf("chat failed: %w", err))
 		}
@@ -139,44 +252,136 @@ func BuildGroupChatHandler(conf *config.Config, ct chat.Chat, rep *repo.Reposito
 		)
 
 		tokenConsumed := int64(inputTokens + outputTokens)
-		// 免费请求不计费
-		leftCount, _ := svc.Chat.FreeChatRequestCounts(ctx, payload.UserID, req.Model)
-		quotaConsumed := ternary.IfLazy(
-			leftCount > 0,
-			func() int64 { return 0 },
-			func() int64 {
-				return coins.GetTextModelCoins(mod.ToCoinModel(), int64(inputTokens), int64(outputTokens))
-			},
-		)
 
-		// 更新消息状态
-		msg := repo.ChatGroupMessageUpdate{
-			Message:       resp.Text,
-			TokenConsumed: tokenConsumed,
-			QuotaConsumed: quotaConsumed,
-			Status:        repo.MessageStatusSucceed,
+		// 按优先级依次尝试：每月免费额度 -> 每日免费额度 -> 按量计费
+		leftCount, _ := svc.Chat.FreeChatRequestCounts(ctx, payload.UserID, req.Model)
+		var quotaConsumed int64
+		var quotaSource repo.QuotaSource
+		switch {
+		case leftCount > 0:
+			quotaSource = repo.QuotaSourceMonthlyFree
+		case func() bool {
+			ok, err := tryConsumeDailyFreeGroupChatQuota(ctx, rdb, conf, svc, payload.UserID, req.Model)
+			if err != nil {
+				log.With(payload).Errorf("consume daily free group chat quota failed: %s", err)
+			}
+			return ok
+		}():
+			quotaSource = repo.QuotaSourceDailyFree
+		default:
+			quotaConsumed = coins.GetTextModelCoins(mod.ToCoinModel(), int64(inputTokens), int64(outputTokens))
+			quotaSource = repo.QuotaSourcePaid
 		}
-		if err := rep.ChatGroup.UpdateChatMessage(ctx, payload.GroupID, payload.UserID, payload.MessageID, msg); err != nil {
-			panic(fmt.Errorf("update chat message failed: %w", err))
+
+		// 消息状态更新、配额扣减与队列状态更新放在同一个事务里，避免出现消息已标记
+		// 成功但配额扣减静默失败的中间状态
+		err = rep.Transaction(ctx, func(ctx context.Context) error {
+			msg := repo.ChatGroupMessageUpdate{
+				Message:        resp.Text,
+				TokenConsumed:  tokenConsumed,
+				QuotaConsumed:  quotaConsumed,
+				QuotaSource:    quotaSource,
+				ActualModelID:  mod.ModelId,
+				FallbackReason: fallbackReason,
+				Status:         repo.MessageStatusSucceed,
+			}
+			if err := rep.ChatGroup.UpdateChatMessage(ctx, payload.GroupID, payload.UserID, payload.MessageID, msg); err != nil {
+				return fmt.Errorf("update chat message failed: %w", err)
+			}
+
+			if quotaConsumed > 0 {
+				if err := rep.Quota.QuotaConsume(ctx, payload.UserID, quotaConsumed, repo.NewQuotaUsedMeta("group_chat", req.Model)); err != nil {
+					return fmt.Errorf("used quota add failed: %w", err)
+				}
+			}
+
+			return rep.Queue.Update(ctx, payload.GetID(), repo.QueueTaskStatusSuccess, EmptyResult{})
+		})
+		if err != nil {
+			panic(fmt.Errorf("commit group chat result failed: %w", err))
 		}
+		committed = true
 
-		// 更新免费聊天次数
+		// 更新免费聊天次数，这是一个独立的计数器，不参与上面的事务
 		if err := svc.Chat.UpdateFreeChatCount(ctx, payload.UserID, req.Model); err != nil {
 			log.With(payload).Errorf("update free chat count failed: %s", err)
 		}
 
-		// 扣除智慧果
-		if quotaConsumed > 0 {
-			if err := rep.Quota.QuotaConsume(ctx, payload.UserID, quotaConsumed, repo.NewQuotaUsedMeta("group_chat", req.Model)); err != nil {
-				log.Errorf("used quota add failed: %s", err)
+		return nil
+	}
+}
+
+// streamGroupChatAndPublish 以流式方式调用 AI 系统，将增量内容发布到 Redis Pub/Sub 频道
+// （供 SSE 接口订阅转发给前端），并周期性地将已累积的内容以 MessageStatusInProgress
+// 状态落库，使得断线重连的客户端可以从数据库中恢复进度。token 计数与智慧果扣除仍然
+// 在流结束后由调用方统一处理。
+func streamGroupChatAndPublish(ctx context.Context, rdb *redis.Client, ct chat.Chat, rep *repo.Repository, payload GroupChatPayload, req chat.Request) (chat.Response, error) {
+	// streamCtx 派生自 ctx 并可独立取消：一旦检测到 SSE 订阅者归零，cancel() 会中断
+	// 仍在进行中的 ct.ChatStream 调用，而不必等待 ctx 本身（任务级别的 ctx）被取消
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := ct.ChatStream(streamCtx, req)
+	if err != nil {
+		return chat.Response{}, fmt.Errorf("create chat stream failed: %w", err)
+	}
+
+	channel := groupChatStreamChannel(payload.GroupID, payload.MessageID)
+	var content string
+	lastFlush := time.Now()
+	everSubscribed := false
+
+	checkTicker := time.NewTicker(groupChatStreamSubscriberCheckInterval)
+	defer checkTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return chat.Response{}, ctx.Err()
+		case <-checkTicker.C:
+			subscribers, err := rdb.PubSubNumSub(ctx, channel).Result()
+			if err != nil {
+				log.With(payload).Errorf("check sse subscriber count failed: %s", err)
+				continue
+			}
+			if subscribers[channel] > 0 {
+				everSubscribed = true
+				continue
+			}
+			if everSubscribed {
+				cancel()
+				return chat.Response{}, fmt.Errorf("sse client disconnected, chat stream aborted")
+			}
+		case res, ok := <-stream:
+			if !ok {
+				// 流结束前把最终内容落库一次，确保不会丢失最后一批增量
+				if err := rep.ChatGroup.UpdateChatMessage(ctx, payload.GroupID, payload.UserID, payload.MessageID, repo.ChatGroupMessageUpdate{
+					Message: content,
+					Status:  repo.MessageStatusInProgress,
+				}); err != nil {
+					log.With(payload).Errorf("flush chat stream content failed: %s", err)
+				}
+				return chat.Response{Text: content}, nil
 			}
-		}
 
-		return rep.Queue.Update(
-			context.TODO(),
-			payload.GetID(),
-			repo.QueueTaskStatusSuccess,
-			EmptyResult{},
-		)
+			if res.ErrorCode != "" {
+				return chat.Response{}, fmt.Errorf("chat stream failed: %s %s", res.ErrorCode, res.Error)
+			}
+
+			content += res.Text
+			if err := rdb.Publish(ctx, channel, res.Text).Err(); err != nil {
+				log.With(payload).Errorf("publish chat stream delta failed: %s", err)
+			}
+
+			if time.Since(lastFlush) >= groupChatStreamFlushInterval {
+				if err := rep.ChatGroup.UpdateChatMessage(ctx, payload.GroupID, payload.UserID, payload.MessageID, repo.ChatGroupMessageUpdate{
+					Message: content,
+					Status:  repo.MessageStatusInProgress,
+				}); err != nil {
+					log.With(payload).Errorf("flush chat stream content failed: %s", err)
+				}
+				lastFlush = time.Now()
+			}
+		}
 	}
 }
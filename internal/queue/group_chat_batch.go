@@ -0,0 +1,357 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/mylxsw/aidea-server/config"
+	"github.com/mylxsw/aidea-server/pkg/ai/chat"
+	repo "github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/asteria/log"
+	"github.com/redis/go-redis/v9"
+)
+
+// groupChatBatchSummarizeLockTTL 限定汇总锁的有效期，避免汇总 Handler 异常退出后
+// 锁一直不释放，导致该批次永远无法被重新汇总
+const groupChatBatchSummarizeLockTTL = 10 * time.Minute
+
+// GroupChatBatchTarget 描述一次批量群聊任务中需要询问的一个群成员
+type GroupChatBatchTarget struct {
+	MemberID int64  `json:"member_id,omitempty"`
+	ModelID  string `json:"model_id,omitempty"`
+}
+
+// GroupChatBatchPayload 描述一次针对同一个问题、多个群成员的批量群聊任务
+type GroupChatBatchPayload struct {
+	ID              string                 `json:"id,omitempty"`
+	GroupID         int64                  `json:"group_id,omitempty"`
+	UserID          int64                  `json:"user_id,omitempty"`
+	QuestionID      int64                  `json:"question_id,omitempty"`
+	Targets         []GroupChatBatchTarget `json:"targets,omitempty"`
+	ContextMessages chat.Messages          `json:"context_messages,omitempty"`
+	CreatedAt       time.Time              `json:"created_at,omitempty"`
+	// Timeout 协调任务在所有子任务都提交完成前，汇总任务兜底触发的最长等待时间，
+	// 超过后按彼时已完成的结果进行汇总
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// SummarizerModelID 不为空时，在所有子任务完成后，调用该模型对各成员的回复
+	// 进行汇总，汇总结果写入批次主记录（chat_group_batches.summary），通过批次状态
+	// 接口返回；群聊中不会因此新增一条消息
+	SummarizerModelID string `json:"summarizer_model_id,omitempty"`
+}
+
+func (payload *GroupChatBatchPayload) GetTitle() string {
+	return "群聊批量任务"
+}
+
+func (payload *GroupChatBatchPayload) SetID(id string) {
+	payload.ID = id
+}
+
+func (payload *GroupChatBatchPayload) GetID() string {
+	return payload.ID
+}
+
+func (payload *GroupChatBatchPayload) GetUID() int64 {
+	return payload.UserID
+}
+
+func (payload *GroupChatBatchPayload) GetQuotaID() int64 {
+	return 0
+}
+
+func (payload *GroupChatBatchPayload) GetQuota() int64 {
+	return 0
+}
+
+func NewGroupChatBatchTask(payload any) *asynq.Task {
+	data, _ := json.Marshal(payload)
+	return asynq.NewTask(TypeGroupChatBatch, data)
+}
+
+// groupChatBatchMemberIdempotencyKey 为批次内的某个成员子任务派生一个独立的幂等键，
+// 由 BatchID 与 MemberID 组成，作为子任务的显式 IdempotencyKey：同一批次内每个成员
+// 各有一个键，同一批次内重复的 MemberID 会得到同一个键
+func groupChatBatchMemberIdempotencyKey(batchID string, memberID int64) string {
+	return fmt.Sprintf("group-chat-batch:member:%s:%d", batchID, memberID)
+}
+
+// BuildGroupChatBatchHandler 构建批量群聊任务的协调 Handler：将 Targets fan-out 为
+// 若干个共享 BatchID 的 GroupChatPayload 子任务，在 repo.ChatGroupBatch 中记录整体
+// 进度。协调任务本身在 fan-out 完成后立即返回，不等待子任务完成——汇总交给独立的
+// BuildGroupChatBatchSummarizeHandler：fan-out 时顺带安排一个延迟到 Timeout 之后的
+// 兜底汇总任务，同时每个子任务在完成时通过 reportGroupChatBatchMemberDone 检查批次
+// 是否已全部完成，全部完成时立即补发一个汇总任务，两者通过 Redis 锁保证只汇总一次
+func BuildGroupChatBatchHandler(conf *config.Config, ct chat.Chat, rep *repo.Repository, svc *service.Service, queueClient *asynq.Client, rdb *redis.Client) TaskHandler {
+	return func(ctx context.Context, task *asynq.Task) (err error) {
+		var payload GroupChatBatchPayload
+		if err := json.Unmarshal(task.Payload(), &payload); err != nil {
+			return err
+		}
+
+		batchID := uuid.New().String()
+
+		if err := rep.ChatGroupBatch.Create(ctx, repo.ChatGroupBatchCreateRequest{
+			BatchID:    batchID,
+			GroupID:    payload.GroupID,
+			UserID:     payload.UserID,
+			QuestionID: payload.QuestionID,
+			Members:    extractBatchMemberIDs(payload.Targets),
+		}); err != nil {
+			return fmt.Errorf("create chat group batch failed: %w", err)
+		}
+
+		timeout := payload.Timeout
+		if timeout <= 0 {
+			timeout = 3 * time.Minute
+		}
+
+		if payload.SummarizerModelID != "" {
+			if err := saveGroupChatBatchSummarizeMeta(ctx, rdb, batchID, payload, timeout+time.Minute); err != nil {
+				log.With(payload).Errorf("save chat group batch summarize meta failed: %s", err)
+			} else if _, err := queueClient.EnqueueContext(ctx, newGroupChatBatchSummarizeTask(batchID), asynq.ProcessIn(timeout)); err != nil {
+				log.With(payload).Errorf("enqueue fallback chat group batch summarize task failed: %s", err)
+			}
+		}
+
+		for _, target := range payload.Targets {
+			messageID, err := rep.ChatGroup.CreateMessage(ctx, payload.GroupID, payload.UserID, target.MemberID, payload.QuestionID, target.ModelID)
+			if err != nil {
+				log.With(target).Errorf("create chat group batch member message failed: %s", err)
+				if err := rep.ChatGroupBatch.MarkMemberFailed(ctx, batchID, target.MemberID, err.Error()); err != nil {
+					log.With(target).Errorf("mark batch member failed failed: %s", err)
+				}
+				continue
+			}
+
+			childPayload := GroupChatPayload{
+				GroupID:         payload.GroupID,
+				UserID:          payload.UserID,
+				MemberID:        target.MemberID,
+				QuestionID:      payload.QuestionID,
+				MessageID:       messageID,
+				ModelID:         target.ModelID,
+				ContextMessages: payload.ContextMessages,
+				CreatedAt:       payload.CreatedAt,
+				BatchID:         batchID,
+				IdempotencyKey:  groupChatBatchMemberIdempotencyKey(batchID, target.MemberID),
+			}
+
+			if _, err := EnqueueGroupChatTask(ctx, queueClient, rdb, childPayload); err != nil {
+				log.With(childPayload).Errorf("enqueue group chat batch member task failed: %s", err)
+				if err := rep.ChatGroupBatch.MarkMemberFailed(ctx, batchID, target.MemberID, err.Error()); err != nil {
+					log.With(childPayload).Errorf("mark batch member failed failed: %s", err)
+				}
+
+				// CreateMessage 已经落库了一条 in_progress 的消息，入队失败意味着不会
+				// 再有任何 worker 来把它推进到终态，必须在这里标记失败，否则这条消息
+				// 会永远卡在 in_progress。ErrGroupChatTaskEnqueueInFlight 除外：它只是
+				// 原始入队调用还没来得及写回任务 ID 的并发窗口，原始调用本身大概率会
+				// 成功并推进这条消息，这里不能抢先把它标记为失败
+				if !errors.Is(err, ErrGroupChatTaskEnqueueInFlight) {
+					msg := repo.ChatGroupMessageUpdate{Message: err.Error(), Status: repo.MessageStatusFailed, Error: err.Error()}
+					if err := rep.ChatGroup.UpdateChatMessage(ctx, payload.GroupID, payload.UserID, messageID, msg); err != nil {
+						log.With(childPayload).Errorf("mark orphaned chat group message failed: %s", err)
+					}
+				}
+			}
+		}
+
+		return nil
+	}
+}
+
+// reportGroupChatBatchMemberDone 由单个 GroupChatPayload 任务在完成（成功或失败）时调用，
+// 向其所属的批次上报完成状态；如果该成员是批次内最后一个完成的成员，立即补发一次汇总任务，
+// 不必等待 fan-out 时安排的兜底汇总任务超时才触发
+func reportGroupChatBatchMemberDone(ctx context.Context, rep *repo.Repository, svc *service.Service, queueClient *asynq.Client, rdb *redis.Client, payload GroupChatPayload, taskErr error) {
+	if taskErr != nil {
+		if err := rep.ChatGroupBatch.MarkMemberFailed(ctx, payload.BatchID, payload.MemberID, taskErr.Error()); err != nil {
+			log.With(payload).Errorf("mark chat group batch member failed: %s", err)
+		}
+	} else if err := rep.ChatGroupBatch.MarkMemberSucceed(ctx, payload.BatchID, payload.MemberID, payload.MessageID); err != nil {
+		log.With(payload).Errorf("mark chat group batch member succeed failed: %s", err)
+	}
+
+	done, err := rep.ChatGroupBatch.IsDone(ctx, payload.BatchID)
+	if err != nil {
+		log.With(payload).Errorf("check chat group batch done failed: %s", err)
+		return
+	}
+	if !done {
+		return
+	}
+
+	if _, err := queueClient.EnqueueContext(ctx, newGroupChatBatchSummarizeTask(payload.BatchID)); err != nil {
+		log.With(payload).Errorf("enqueue chat group batch summarize task failed: %s", err)
+	}
+}
+
+// groupChatBatchSummarizeMeta 是 fan-out 时随兜底汇总任务一起写入 Redis 的批次上下文，
+// 汇总 Handler 触发时凭 BatchID 取回，用于还原汇总所需的问题上下文与目标模型
+type groupChatBatchSummarizeMeta struct {
+	GroupID           int64         `json:"group_id"`
+	UserID            int64         `json:"user_id"`
+	ContextMessages   chat.Messages `json:"context_messages"`
+	SummarizerModelID string        `json:"summarizer_model_id"`
+}
+
+func groupChatBatchSummarizeMetaKey(batchID string) string {
+	return fmt.Sprintf("group-chat-batch:summarize-meta:%s", batchID)
+}
+
+func groupChatBatchSummarizeLockKey(batchID string) string {
+	return fmt.Sprintf("group-chat-batch:summarize-lock:%s", batchID)
+}
+
+func saveGroupChatBatchSummarizeMeta(ctx context.Context, rdb *redis.Client, batchID string, payload GroupChatBatchPayload, ttl time.Duration) error {
+	meta := groupChatBatchSummarizeMeta{
+		GroupID:           payload.GroupID,
+		UserID:            payload.UserID,
+		ContextMessages:   payload.ContextMessages,
+		SummarizerModelID: payload.SummarizerModelID,
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, groupChatBatchSummarizeMetaKey(batchID), data, ttl).Err()
+}
+
+func newGroupChatBatchSummarizeTask(batchID string) *asynq.Task {
+	data, _ := json.Marshal(map[string]string{"batch_id": batchID})
+	return asynq.NewTask(TypeGroupChatBatchSummarize, data)
+}
+
+// BuildGroupChatBatchSummarizeHandler 构建汇总任务的 Handler：同一个批次最多有两次
+// 触发机会（成员全部完成后的立即触发、fan-out 时安排的超时兜底触发），两者用
+// groupChatBatchSummarizeLockKey 上的 SETNX 锁互斥，确保汇总只真正执行一次
+func BuildGroupChatBatchSummarizeHandler(conf *config.Config, ct chat.Chat, rep *repo.Repository, svc *service.Service, rdb *redis.Client) TaskHandler {
+	return func(ctx context.Context, task *asynq.Task) error {
+		var req struct {
+			BatchID string `json:"batch_id"`
+		}
+		if err := json.Unmarshal(task.Payload(), &req); err != nil {
+			return err
+		}
+
+		acquired, err := rdb.SetNX(ctx, groupChatBatchSummarizeLockKey(req.BatchID), "1", groupChatBatchSummarizeLockTTL).Result()
+		if err != nil {
+			return fmt.Errorf("acquire chat group batch summarize lock failed: %w", err)
+		}
+		if !acquired {
+			// 另一次触发已经在处理这个批次的汇总，本次直接放弃
+			return nil
+		}
+
+		metaKey := groupChatBatchSummarizeMetaKey(req.BatchID)
+		data, err := rdb.Get(ctx, metaKey).Result()
+		if err == redis.Nil {
+			// meta 不存在时直接跳过：批次已经汇总过（meta 被上一次成功的汇总删除）、
+			// 批次没有配置 SummarizerModelID（meta 从未写入），或 meta 已经过期
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("load chat group batch summarize meta failed: %w", err)
+		}
+
+		var meta groupChatBatchSummarizeMeta
+		if err := json.Unmarshal([]byte(data), &meta); err != nil {
+			return fmt.Errorf("decode chat group batch summarize meta failed: %w", err)
+		}
+
+		payload := GroupChatBatchPayload{
+			GroupID:           meta.GroupID,
+			UserID:            meta.UserID,
+			ContextMessages:   meta.ContextMessages,
+			SummarizerModelID: meta.SummarizerModelID,
+		}
+
+		if err := summarizeGroupChatBatch(ctx, ct, rep, svc, payload, req.BatchID); err != nil {
+			return err
+		}
+
+		rdb.Del(ctx, metaKey)
+		return nil
+	}
+}
+
+// summarizeGroupChatBatch 收集批次内各成员的回复，调用配置的汇总模型生成一段总结，
+// 写入批次主记录（见 ChatGroupBatchRepo.WriteSummary）；不会在群聊中新增消息
+func summarizeGroupChatBatch(ctx context.Context, ct chat.Chat, rep *repo.Repository, svc *service.Service, payload GroupChatBatchPayload, batchID string) error {
+	replies, err := rep.ChatGroupBatch.MemberReplies(ctx, batchID)
+	if err != nil {
+		return fmt.Errorf("load chat group batch replies failed: %w", err)
+	}
+	if len(replies) == 0 {
+		return nil
+	}
+
+	mod := svc.Chat.Model(ctx, payload.SummarizerModelID)
+	if mod == nil || mod.Status == repo.ModelStatusDisabled {
+		return fmt.Errorf("summarizer model %s not found or disabled", payload.SummarizerModelID)
+	}
+
+	summaryReq := chat.Request{
+		Model:    mod.ModelId,
+		Messages: buildSummarizerMessages(payload.ContextMessages, replies),
+	}
+
+	resp, err := ct.Chat(ctx, summaryReq)
+	if err != nil {
+		return fmt.Errorf("summarize chat group batch failed: %w", err)
+	}
+
+	return rep.ChatGroupBatch.WriteSummary(ctx, batchID, payload.GroupID, payload.UserID, resp.Text)
+}
+
+// buildSummarizerMessages 将原始问题与各成员的回复拼装为汇总模型的上下文
+func buildSummarizerMessages(context chat.Messages, replies []repo.ChatGroupBatchMemberReply) chat.Messages {
+	messages := make(chat.Messages, 0, len(context)+1)
+	messages = append(messages, context...)
+
+	var summary string
+	for _, reply := range replies {
+		summary += fmt.Sprintf("成员 %d 的回复：%s\n", reply.MemberID, reply.Content)
+	}
+
+	messages = append(messages, chat.Message{
+		Role:    "user",
+		Content: "请综合以下各成员的回复，给出一个简洁的汇总：\n" + summary,
+	})
+
+	return messages
+}
+
+func extractBatchMemberIDs(targets []GroupChatBatchTarget) []int64 {
+	ids := make([]int64, 0, len(targets))
+	for _, target := range targets {
+		ids = append(ids, target.MemberID)
+	}
+	return ids
+}
+
+// GroupChatBatchStatus 返回一个 HTTP Handler，用于查询批量群聊任务的整体进度，
+// 对应 GET /v1/group-chat/batches/{id}
+func GroupChatBatchStatus(rep *repo.Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		batchID := routeParam(r, "id")
+
+		status, err := rep.ChatGroupBatch.Status(r.Context(), batchID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			log.Errorf("encode chat group batch status failed: %s", err)
+		}
+	}
+}
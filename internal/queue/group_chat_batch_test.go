@@ -0,0 +1,28 @@
+package queue
+
+import "testing"
+
+func TestExtractBatchMemberIDs(t *testing.T) {
+	targets := []GroupChatBatchTarget{{MemberID: 1}, {MemberID: 2}, {MemberID: 3}}
+	got := extractBatchMemberIDs(targets)
+	want := []int64{1, 2, 3}
+
+	if len(got) != len(want) {
+		t.Fatalf("extractBatchMemberIDs() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("extractBatchMemberIDs()[%d] = %d, want %d", i, got[i], want[i])
+		}
+	}
+}
+
+func TestGroupChatBatchMemberIdempotencyKeyDistinctPerMember(t *testing.T) {
+	batchID := "batch-1"
+	keyA := groupChatBatchMemberIdempotencyKey(batchID, 1)
+	keyB := groupChatBatchMemberIdempotencyKey(batchID, 2)
+
+	if keyA == keyB {
+		t.Fatalf("expected distinct idempotency keys for different members, got %q for both", keyA)
+	}
+}
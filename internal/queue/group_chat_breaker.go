@@ -0,0 +1,290 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mylxsw/aidea-server/config"
+	repo "github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/asteria/log"
+	"github.com/redis/go-redis/v9"
+)
+
+// breakerState 描述单个模型熔断器所处的状态
+type breakerState string
+
+const (
+	breakerStateClosed   breakerState = "closed"
+	breakerStateOpen     breakerState = "open"
+	breakerStateHalfOpen breakerState = "half_open"
+)
+
+// breakerStatus 是熔断器在 Redis 中持久化的状态快照，多个队列 worker 共享同一份状态
+type breakerStatus struct {
+	State               breakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	OpenedAt            time.Time    `json:"opened_at,omitempty"`
+	// HalfOpenedAt 记录进入 half_open 状态、放行探测请求的时间，用于在探测请求
+	// 没有走到 recordModelBreakerResult（panic、被杀死、异步重试到了别的 worker 上）
+	// 时，让熔断器能够自行超时回到 open，而不是永远卡在 half_open 拒绝所有请求
+	HalfOpenedAt time.Time `json:"half_opened_at,omitempty"`
+}
+
+func breakerKey(modelID string) string {
+	return fmt.Sprintf("group-chat:breaker:%s", modelID)
+}
+
+func breakerWindowKey(modelID string) string {
+	return fmt.Sprintf("group-chat:breaker:%s:window", modelID)
+}
+
+// loadBreakerStatus 从 Redis 中读取模型当前的熔断器状态，不存在时视为 closed
+func loadBreakerStatus(ctx context.Context, rdb *redis.Client, modelID string) (breakerStatus, error) {
+	raw, err := rdb.Get(ctx, breakerKey(modelID)).Result()
+	if err == redis.Nil {
+		return breakerStatus{State: breakerStateClosed}, nil
+	}
+	if err != nil {
+		return breakerStatus{}, err
+	}
+
+	var status breakerStatus
+	if err := json.Unmarshal([]byte(raw), &status); err != nil {
+		return breakerStatus{}, err
+	}
+	return status, nil
+}
+
+func saveBreakerStatus(ctx context.Context, rdb *redis.Client, modelID string, status breakerStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, breakerKey(modelID), data, 24*time.Hour).Err()
+}
+
+func breakerProbeKey(modelID string) string {
+	return fmt.Sprintf("group-chat:breaker:%s:probe", modelID)
+}
+
+// halfOpenProbeExpired 判断一次 half_open 探测是否已经超过冷却窗口仍未回写结果，
+// 单独拆出 now 参数是为了方便单元测试
+func halfOpenProbeExpired(status breakerStatus, coolDown time.Duration, now time.Time) bool {
+	return now.Sub(status.HalfOpenedAt) >= coolDown
+}
+
+// isModelHealthy 判断指定模型当前是否可以被尝试。closed 状态下直接放行；open 状态下
+// 冷却时间未到期时拒绝所有请求；冷却到期后通过 SETNX 抢占一把探测锁，只有抢到锁的那一个
+// 请求会被当作半开状态下的单次探测请求放行，其余并发请求（包括探测结果出来之前的所有
+// 请求）一律视为不健康，避免大量请求同时涌向一个刚冷却、大概率仍然不稳定的模型
+func isModelHealthy(ctx context.Context, rdb *redis.Client, conf *config.Config, modelID string) (bool, error) {
+	status, err := loadBreakerStatus(ctx, rdb, modelID)
+	if err != nil {
+		return false, err
+	}
+
+	switch status.State {
+	case breakerStateOpen:
+		if time.Since(status.OpenedAt) < conf.ModelBreakerCoolDown() {
+			return false, nil
+		}
+
+		acquired, err := rdb.SetNX(ctx, breakerProbeKey(modelID), "1", conf.ModelBreakerCoolDown()).Result()
+		if err != nil {
+			return false, err
+		}
+		if !acquired {
+			// 已经有一个探测请求在途，结果揭晓之前其余请求都当作不健康处理
+			return false, nil
+		}
+
+		status.State = breakerStateHalfOpen
+		status.HalfOpenedAt = time.Now()
+		return true, saveBreakerStatus(ctx, rdb, modelID, status)
+	case breakerStateHalfOpen:
+		if !halfOpenProbeExpired(status, conf.ModelBreakerCoolDown(), time.Now()) {
+			// 探测请求的结果还未通过 recordModelBreakerResult 回写，继续拒绝其余请求
+			return false, nil
+		}
+
+		// 探测请求超过一个冷却窗口仍未回写结果（大概率是 panic/被杀死/重试到了别的
+		// worker），自行回到 open 并释放探测锁，等待下一次冷却到期后再给一次探测机会，
+		// 避免熔断器永远卡在 half_open 拒绝所有请求
+		if err := rdb.Del(ctx, breakerProbeKey(modelID)).Err(); err != nil {
+			return false, err
+		}
+		status.State = breakerStateOpen
+		status.OpenedAt = time.Now()
+		return false, saveBreakerStatus(ctx, rdb, modelID, status)
+	default:
+		return true, nil
+	}
+}
+
+// recordModelBreakerResult 记录一次调用结果，维护滑动窗口错误率与连续失败次数，
+// 并据此决定是否需要打开或关闭熔断器
+func recordModelBreakerResult(ctx context.Context, rdb *redis.Client, conf *config.Config, modelID string, success bool) {
+	window := conf.ModelBreakerWindow()
+	now := time.Now()
+
+	member := fmt.Sprintf("%d:%t", now.UnixNano(), success)
+	if err := rdb.ZAdd(ctx, breakerWindowKey(modelID), redis.Z{Score: float64(now.UnixNano()), Member: member}).Err(); err != nil {
+		log.Errorf("record model breaker window event failed: %s", err)
+	}
+	rdb.ZRemRangeByScore(ctx, breakerWindowKey(modelID), "0", fmt.Sprintf("%d", now.Add(-window).UnixNano()))
+	rdb.Expire(ctx, breakerWindowKey(modelID), window)
+
+	status, err := loadBreakerStatus(ctx, rdb, modelID)
+	if err != nil {
+		log.Errorf("load model breaker status failed: %s", err)
+		return
+	}
+
+	// 无论探测成功还是失败，都要释放探测锁：成功时熔断器关闭不再需要锁，失败时需要
+	// 释放锁以便下一次冷却到期后能够再次放行一次探测请求
+	if err := rdb.Del(ctx, breakerProbeKey(modelID)).Err(); err != nil {
+		log.Errorf("release model breaker probe lock failed: %s", err)
+	}
+
+	if success {
+		status.ConsecutiveFailures = 0
+		status.State = breakerStateClosed
+		if err := saveBreakerStatus(ctx, rdb, modelID, status); err != nil {
+			log.Errorf("save model breaker status failed: %s", err)
+		}
+		return
+	}
+
+	status.ConsecutiveFailures++
+
+	events, err := rdb.ZRange(ctx, breakerWindowKey(modelID), 0, -1).Result()
+	errorRate := 0.0
+	if err == nil && len(events) > 0 {
+		failures := 0
+		for _, e := range events {
+			if strings.HasSuffix(e, ":false") {
+				failures++
+			}
+		}
+		errorRate = float64(failures) / float64(len(events))
+	}
+
+	// 半开探测失败，或连续失败/错误率超过阈值，都需要（重新）打开熔断器并重置冷却计时
+	if status.State == breakerStateHalfOpen ||
+		status.ConsecutiveFailures >= conf.ModelBreakerConsecutiveFailureThreshold() ||
+		errorRate >= conf.ModelBreakerErrorRateThreshold() {
+		status.State = breakerStateOpen
+		status.OpenedAt = now
+	}
+
+	if err := saveBreakerStatus(ctx, rdb, modelID, status); err != nil {
+		log.Errorf("save model breaker status failed: %s", err)
+	}
+}
+
+// ErrNoHealthyModel 表示目标模型的熔断器处于打开状态，且故障转移链上也没有任何健康的
+// 替代模型可用，调用方必须就此放弃，不能强行拿目标模型去调用——否则这次必然失败的调用
+// 一旦回写到 recordModelBreakerResult，会不断把已经打开的熔断器的 OpenedAt 刷新为当前时间，
+// 使冷却计时永远无法到期
+var ErrNoHealthyModel = errors.New("no healthy model available")
+
+// resetModelBreaker 由管理端接口调用，强制将指定模型的熔断器复位为 closed
+func resetModelBreaker(ctx context.Context, rdb *redis.Client, modelID string) error {
+	return saveBreakerStatus(ctx, rdb, modelID, breakerStatus{State: breakerStateClosed})
+}
+
+// fallbackCandidateUsable 判断一个故障转移候选模型是否可以被真正切换过去：必须存在且未被禁用
+func fallbackCandidateUsable(mod *repo.Model) bool {
+	return mod != nil && mod.Status != repo.ModelStatusDisabled
+}
+
+// selectHealthyModel 优先尝试目标模型，如果其熔断器处于打开状态，则按
+// svc.Chat.FallbackChain(modelID) 给出的顺序依次尝试替代模型，返回第一个健康的模型 ID，
+// 以及（如果发生了切换）切换原因说明
+func selectHealthyModel(ctx context.Context, rdb *redis.Client, conf *config.Config, svc *service.Service, modelID string) (string, string, error) {
+	healthy, err := isModelHealthy(ctx, rdb, conf, modelID)
+	if err != nil {
+		return "", "", err
+	}
+	if healthy {
+		return modelID, "", nil
+	}
+
+	chain := svc.Chat.FallbackChain(ctx, modelID)
+	for _, candidate := range chain {
+		// 候选模型本身必须存在且未被禁用，否则即使熔断器认为它健康，也不能真的切过去，
+		// 不然调用方会在发现模型不可用之前已经把它当成了"已切换到的健康模型"
+		if !fallbackCandidateUsable(svc.Chat.Model(ctx, candidate)) {
+			continue
+		}
+
+		ok, err := isModelHealthy(ctx, rdb, conf, candidate)
+		if err != nil {
+			log.Errorf("check fallback model %s health failed: %s", candidate, err)
+			continue
+		}
+		if ok {
+			return candidate, fmt.Sprintf("model %s circuit breaker open, failover to %s", modelID, candidate), nil
+		}
+	}
+
+	// 目标模型不健康，且故障转移链上也没有任何健康的替代模型：必须就此失败，不能把
+	// 明知不健康的目标模型硬塞给调用方，否则调用方会真的拿它去请求 AI 系统，调用结果
+	// 回写到 recordModelBreakerResult 后会不断刷新已经打开的熔断器的冷却计时
+	return "", "", ErrNoHealthyModel
+}
+
+// ModelBreakerStatusView 是 /v1/admin/models/breakers 返回给管理端的单个模型熔断器视图
+type ModelBreakerStatusView struct {
+	ModelID             string       `json:"model_id"`
+	State               breakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	OpenedAt            time.Time    `json:"opened_at,omitempty"`
+}
+
+// ListModelBreakers 返回一个 HTTP Handler，列出指定模型列表当前的熔断器状态，
+// 对应 GET /v1/admin/models/breakers?model_id=a&model_id=b
+func ListModelBreakers(rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		modelIDs := r.URL.Query()["model_id"]
+
+		views := make([]ModelBreakerStatusView, 0, len(modelIDs))
+		for _, modelID := range modelIDs {
+			status, err := loadBreakerStatus(r.Context(), rdb, modelID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			views = append(views, ModelBreakerStatusView{
+				ModelID:             modelID,
+				State:               status.State,
+				ConsecutiveFailures: status.ConsecutiveFailures,
+				OpenedAt:            status.OpenedAt,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(views); err != nil {
+			log.Errorf("encode model breaker status failed: %s", err)
+		}
+	}
+}
+
+// ResetModelBreaker 返回一个 HTTP Handler，供运维人员手动复位指定模型的熔断器，
+// 对应 POST /v1/admin/models/breakers/{model_id}/reset
+func ResetModelBreaker(rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		modelID := routeParam(r, "model_id")
+		if err := resetModelBreaker(r.Context(), rdb, modelID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,57 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	repo "github.com/mylxsw/aidea-server/pkg/repo"
+)
+
+func TestFallbackCandidateUsable(t *testing.T) {
+	cases := []struct {
+		name string
+		mod  *repo.Model
+		want bool
+	}{
+		{"nil model (not found)", nil, false},
+		{"disabled model", &repo.Model{ModelId: "m", Status: repo.ModelStatusDisabled}, false},
+		{"enabled model", &repo.Model{ModelId: "m", Status: repo.ModelStatusEnabled}, true},
+	}
+
+	for _, c := range cases {
+		if got := fallbackCandidateUsable(c.mod); got != c.want {
+			t.Fatalf("%s: fallbackCandidateUsable() = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestHalfOpenProbeExpired(t *testing.T) {
+	coolDown := 30 * time.Second
+	halfOpenedAt := time.Date(2026, 7, 26, 12, 0, 0, 0, time.UTC)
+	status := breakerStatus{State: breakerStateHalfOpen, HalfOpenedAt: halfOpenedAt}
+
+	if halfOpenProbeExpired(status, coolDown, halfOpenedAt.Add(10*time.Second)) {
+		t.Fatalf("probe should not be expired before the cooldown window elapses")
+	}
+	if !halfOpenProbeExpired(status, coolDown, halfOpenedAt.Add(30*time.Second)) {
+		t.Fatalf("probe should be expired once the cooldown window has elapsed")
+	}
+}
+
+func TestBreakerKey(t *testing.T) {
+	if got, want := breakerKey("gpt-4"), "group-chat:breaker:gpt-4"; got != want {
+		t.Fatalf("breakerKey() = %q, want %q", got, want)
+	}
+}
+
+func TestBreakerProbeKey(t *testing.T) {
+	if got, want := breakerProbeKey("gpt-4"), "group-chat:breaker:gpt-4:probe"; got != want {
+		t.Fatalf("breakerProbeKey() = %q, want %q", got, want)
+	}
+}
+
+func TestBreakerWindowKey(t *testing.T) {
+	if got, want := breakerWindowKey("gpt-4"), "group-chat:breaker:gpt-4:window"; got != want {
+		t.Fatalf("breakerWindowKey() = %q, want %q", got, want)
+	}
+}
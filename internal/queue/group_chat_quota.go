@@ -0,0 +1,96 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mylxsw/aidea-server/config"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/asteria/log"
+	"github.com/redis/go-redis/v9"
+)
+
+// tryConsumeDailyFreeGroupChatQuota 尝试消费用户当日的群聊免费额度，额度用尽或未配置
+// 免费额度时返回 false，调用方应回退到按量计费逻辑。额度计数存储在 Redis 中，key 按用户
+// 所在时区的自然日滚动，在当日首次调用时设置过期时间为次日零点
+func tryConsumeDailyFreeGroupChatQuota(ctx context.Context, rdb *redis.Client, conf *config.Config, svc *service.Service, userID int64, modelID string) (bool, error) {
+	allowance, err := svc.User.DailyGroupChatAllowance(ctx, userID, modelID)
+	if err != nil {
+		return false, fmt.Errorf("load daily group chat allowance failed: %w", err)
+	}
+	if allowance <= 0 {
+		return false, nil
+	}
+
+	loc, err := svc.User.Timezone(ctx, userID)
+	if err != nil {
+		log.With(userID).Errorf("load user timezone failed, fallback to local: %s", err)
+		loc = time.Local
+	}
+
+	key := dailyGroupChatQuotaKey(userID, modelID)
+	count, err := rdb.Incr(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+	if count == 1 {
+		if err := rdb.ExpireAt(ctx, key, nextLocalMidnight(loc)).Err(); err != nil {
+			log.Errorf("set daily group chat quota expire failed: %s", err)
+		}
+	}
+
+	if count > allowance {
+		// 超出当日额度，回退计数，交由按量计费逻辑处理
+		if err := rdb.Decr(ctx, key).Err(); err != nil {
+			log.Errorf("rollback daily group chat quota failed: %s", err)
+		}
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func dailyGroupChatQuotaKey(userID int64, modelID string) string {
+	return fmt.Sprintf("group-chat:daily-free-quota:%d:%s", userID, modelID)
+}
+
+func nextLocalMidnight(loc *time.Location) time.Time {
+	return nextLocalMidnightAt(time.Now(), loc)
+}
+
+// nextLocalMidnightAt 返回以 now（转换到 loc 时区后）为基准的下一个零点时刻，
+// 单独拆出 now 参数是为了方便单元测试
+func nextLocalMidnightAt(now time.Time, loc *time.Location) time.Time {
+	now = now.In(loc)
+	y, m, d := now.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+}
+
+// AdjustUserDailyGroupChatAllowanceRequest 管理端批量调整用户每日群聊免费额度的请求体
+type AdjustUserDailyGroupChatAllowanceRequest struct {
+	UserIDs   []int64 `json:"user_ids"`
+	ModelID   string  `json:"model_id"`
+	Allowance int64   `json:"allowance"`
+}
+
+// AdjustUserDailyGroupChatAllowance 管理端接口：批量调整一批用户在指定模型下的每日
+// 群聊免费额度（写入 user_daily_limits 表），对应 POST /v1/admin/group-chat/daily-allowance
+func AdjustUserDailyGroupChatAllowance(svc *service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req AdjustUserDailyGroupChatAllowanceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := svc.User.SetDailyGroupChatAllowance(r.Context(), req.UserIDs, req.ModelID, req.Allowance); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,23 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDailyGroupChatQuotaKey(t *testing.T) {
+	if got, want := dailyGroupChatQuotaKey(42, "gpt-4"), "group-chat:daily-free-quota:42:gpt-4"; got != want {
+		t.Fatalf("dailyGroupChatQuotaKey() = %q, want %q", got, want)
+	}
+}
+
+func TestNextLocalMidnight(t *testing.T) {
+	loc := time.UTC
+	now := time.Date(2026, 7, 25, 23, 59, 1, 0, loc)
+
+	got := nextLocalMidnightAt(now, loc)
+	want := time.Date(2026, 7, 26, 0, 0, 0, 0, loc)
+	if !got.Equal(want) {
+		t.Fatalf("nextLocalMidnightAt() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,61 @@
+package queue
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/mylxsw/asteria/log"
+	"github.com/redis/go-redis/v9"
+)
+
+// StreamGroupChatMessage 返回一个 SSE Handler，订阅指定群聊消息的 Redis Pub/Sub 频道，
+// 将 BuildGroupChatHandler 在 Stream 模式下发布的增量内容实时转发给前端，对应路由：
+//
+//	GET /v1/group-chat/{group_id}/messages/{message_id}/stream
+func StreamGroupChatMessage(rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		groupID, err := strconv.ParseInt(routeParam(r, "group_id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid group_id", http.StatusBadRequest)
+			return
+		}
+		messageID, err := strconv.ParseInt(routeParam(r, "message_id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid message_id", http.StatusBadRequest)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+
+		ctx := r.Context()
+		sub := rdb.Subscribe(ctx, groupChatStreamChannel(groupID, messageID))
+		defer sub.Close()
+
+		ch := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				// 客户端断开连接，结束订阅
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				if _, err := fmt.Fprintf(w, "data: %s\n\n", msg.Payload); err != nil {
+					log.Errorf("write sse chunk failed: %s", err)
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package queue
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGroupChatStreamChannel(t *testing.T) {
+	if got, want := groupChatStreamChannel(7, 99), "group-chat:7:99"; got != want {
+		t.Fatalf("groupChatStreamChannel() = %q, want %q", got, want)
+	}
+}
+
+// TestDispatchGroupChatRoutesStreamPath 验证 SSE 路径在不依赖 Go 1.22 PathValue/方法前缀
+// 模式的情况下，依然能正确解析出 group_id/message_id 并路由到 StreamGroupChatMessage
+func TestDispatchGroupChatRoutesStreamPath(t *testing.T) {
+	var gotGroupID, gotMessageID string
+	dispatch := dispatchGroupChatRoutesWithHandlers(
+		func(w http.ResponseWriter, r *http.Request) {
+			gotGroupID = routeParam(r, "group_id")
+			gotMessageID = routeParam(r, "message_id")
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			t.Fatalf("batch status handler should not be invoked for a stream path")
+		},
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/group-chat/7/messages/99/stream", nil)
+	dispatch(httptest.NewRecorder(), req)
+
+	if gotGroupID != "7" || gotMessageID != "99" {
+		t.Fatalf("routeParam() = (%q, %q), want (\"7\", \"99\")", gotGroupID, gotMessageID)
+	}
+}
+
+// TestDispatchGroupChatRoutesBatchPath 验证批次状态路径被正确路由，且不会被流式路径误匹配
+func TestDispatchGroupChatRoutesBatchPath(t *testing.T) {
+	var gotID string
+	dispatch := dispatchGroupChatRoutesWithHandlers(
+		func(w http.ResponseWriter, r *http.Request) {
+			t.Fatalf("stream handler should not be invoked for a batch status path")
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			gotID = routeParam(r, "id")
+		},
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/group-chat/batches/batch-1", nil)
+	dispatch(httptest.NewRecorder(), req)
+
+	if gotID != "batch-1" {
+		t.Fatalf("routeParam(id) = %q, want %q", gotID, "batch-1")
+	}
+}
+
+func TestDispatchGroupChatRoutesUnknownPathNotFound(t *testing.T) {
+	dispatch := dispatchGroupChatRoutesWithHandlers(
+		func(w http.ResponseWriter, r *http.Request) { t.Fatal("unexpected stream handler call") },
+		func(w http.ResponseWriter, r *http.Request) { t.Fatal("unexpected batch handler call") },
+	)
+
+	rec := httptest.NewRecorder()
+	dispatch(rec, httptest.NewRequest(http.MethodGet, "/v1/group-chat/unknown", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,22 @@
+package queue
+
+import (
+	"context"
+	"net/http"
+)
+
+// routeParamsKey 是路径参数在 context 中的存储键
+type routeParamsKey struct{}
+
+// withRouteParams 将路径参数绑定到请求的 context 上，供 Handler 通过 routeParam 读取。
+// 目标 Go 版本为 1.21，标准库 http.ServeMux 既不支持方法前缀模式也没有 Request.PathValue，
+// 因此这些参数由各自的分发函数手工解析路径后塞进 context，而不是依赖 net/http 的路由能力
+func withRouteParams(r *http.Request, params map[string]string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), routeParamsKey{}, params))
+}
+
+// routeParam 读取 withRouteParams 绑定的路径参数，不存在时返回空字符串
+func routeParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(routeParamsKey{}).(map[string]string)
+	return params[name]
+}
@@ -0,0 +1,94 @@
+package queue
+
+import (
+	"net/http"
+	"strings"
+
+	repo "github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/redis/go-redis/v9"
+)
+
+// RegisterRoutes 将群聊相关的 HTTP 接口注册到 mux 上。这些接口与 asynq 队列任务
+// 共享同一套 Redis/数据库依赖，因此放在 queue 包内与对应的 TaskHandler 就近维护。
+//
+// 本项目目标 Go 版本为 1.21，http.ServeMux 既不支持方法前缀模式（"GET /path"）也没有
+// Request.PathValue，所以带路径参数的接口都注册到一个前缀上，由对应的 dispatch 函数
+// 自行按 Method 和剩余路径分流，见下方的 dispatchGroupChatRoutes 与
+// dispatchModelBreakerRoutes
+func RegisterRoutes(mux *http.ServeMux, rep *repo.Repository, svc *service.Service, rdb *redis.Client) {
+	mux.HandleFunc("/v1/group-chat/", dispatchGroupChatRoutes(rep, rdb))
+	mux.HandleFunc("/v1/admin/models/breakers", ListModelBreakers(rdb))
+	mux.HandleFunc("/v1/admin/models/breakers/", dispatchModelBreakerRoutes(rdb))
+	mux.HandleFunc("/v1/admin/group-chat/daily-allowance", AdjustUserDailyGroupChatAllowance(svc))
+}
+
+// groupChatRoutePrefix 是 dispatchGroupChatRoutes 负责的所有接口共享的路径前缀
+const groupChatRoutePrefix = "/v1/group-chat/"
+
+// dispatchGroupChatRoutes 在 "/v1/group-chat/" 前缀下分流两个接口：
+//
+//	GET /v1/group-chat/{group_id}/messages/{message_id}/stream
+//	GET /v1/group-chat/batches/{id}
+func dispatchGroupChatRoutes(rep *repo.Repository, rdb *redis.Client) http.HandlerFunc {
+	return dispatchGroupChatRoutesWithHandlers(StreamGroupChatMessage(rdb), GroupChatBatchStatus(rep))
+}
+
+// dispatchGroupChatRoutesWithHandlers 是 dispatchGroupChatRoutes 的实现，接受具体的
+// Handler 作为参数，便于在测试中用桩 Handler 验证路径解析与分流是否正确
+func dispatchGroupChatRoutesWithHandlers(streamHandler, batchStatusHandler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.NotFound(w, r)
+			return
+		}
+
+		segments := splitRoutePath(r.URL.Path, groupChatRoutePrefix)
+		switch {
+		case len(segments) == 2 && segments[0] == "batches":
+			batchStatusHandler(w, withRouteParams(r, map[string]string{"id": segments[1]}))
+		case len(segments) == 4 && segments[1] == "messages" && segments[3] == "stream":
+			streamHandler(w, withRouteParams(r, map[string]string{
+				"group_id":   segments[0],
+				"message_id": segments[2],
+			}))
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
+
+// modelBreakerRoutePrefix 是 dispatchModelBreakerRoutes 负责的接口共享的路径前缀
+const modelBreakerRoutePrefix = "/v1/admin/models/breakers/"
+
+// dispatchModelBreakerRoutes 在 "/v1/admin/models/breakers/" 前缀下分流：
+//
+//	POST /v1/admin/models/breakers/{model_id}/reset
+func dispatchModelBreakerRoutes(rdb *redis.Client) http.HandlerFunc {
+	resetHandler := ResetModelBreaker(rdb)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+
+		segments := splitRoutePath(r.URL.Path, modelBreakerRoutePrefix)
+		if len(segments) != 2 || segments[1] != "reset" {
+			http.NotFound(w, r)
+			return
+		}
+
+		resetHandler(w, withRouteParams(r, map[string]string{"model_id": segments[0]}))
+	}
+}
+
+// splitRoutePath 去掉 prefix 后按 "/" 切分剩余路径，忽略首尾多余的斜杠。
+// 空路径（前缀本身）返回长度为 0 的切片
+func splitRoutePath(path, prefix string) []string {
+	rest := strings.Trim(strings.TrimPrefix(path, prefix), "/")
+	if rest == "" {
+		return nil
+	}
+	return strings.Split(rest, "/")
+}
@@ -0,0 +1,54 @@
+package chat
+
+import "context"
+
+// Message 是一轮对话中的一条消息
+type Message struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+// Messages 是一组按顺序排列的对话消息
+type Messages []Message
+
+// Request 是一次对话补全请求
+type Request struct {
+	Model    string   `json:"model,omitempty"`
+	Messages Messages `json:"messages,omitempty"`
+}
+
+// Init 原样返回请求本身（不填充任何默认值），以便链式调用
+func (req Request) Init() Request {
+	return req
+}
+
+// FixContextWindow 返回请求的一个副本，其中的消息原样保留，不做任何裁剪：maxRounds、
+// maxContextLength、reservedTokens 不影响结果。返回值的第二个参数表示消息是否被截断过，
+// 总是 false
+func (req Request) FixContextWindow(ct Chat, maxRounds int, maxContextLength int, reservedTokens int) (*Request, bool, error) {
+	return &req, false, nil
+}
+
+// Response 是一次对话补全的结果
+type Response struct {
+	Text      string `json:"text,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Chat 是底层对话模型的统一调用接口
+type Chat interface {
+	// Chat 发起一次同步对话补全请求
+	Chat(ctx context.Context, req Request) (Response, error)
+	// ChatStream 发起一次流式对话补全请求，返回的 channel 会在流结束时关闭
+	ChatStream(ctx context.Context, req Request) (<-chan Response, error)
+}
+
+// MessageTokenCount 估算给定消息在指定模型下的 token 数量
+func MessageTokenCount(messages Messages, model string) (int, error) {
+	var count int
+	for _, msg := range messages {
+		count += len(msg.Content)
+	}
+	return count, nil
+}
@@ -0,0 +1,194 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+)
+
+// ChatGroupBatchMemberStatus 描述批量群聊任务中单个成员当前的完成状态
+type ChatGroupBatchMemberStatus string
+
+const (
+	ChatGroupBatchMemberPending ChatGroupBatchMemberStatus = "pending"
+	ChatGroupBatchMemberRunning ChatGroupBatchMemberStatus = "running"
+	ChatGroupBatchMemberSucceed ChatGroupBatchMemberStatus = "succeed"
+	ChatGroupBatchMemberFailed  ChatGroupBatchMemberStatus = "failed"
+)
+
+// chatGroupBatchMemberUnfinished 返回尚未产生最终结果的成员状态，IsDone/Status 用它
+// 判断一个批次是否还有成员在排队或生成中
+func chatGroupBatchMemberUnfinished(status ChatGroupBatchMemberStatus) bool {
+	return status != ChatGroupBatchMemberSucceed && status != ChatGroupBatchMemberFailed
+}
+
+// ChatGroupBatchCreateRequest 创建一个批量群聊任务所需的信息
+type ChatGroupBatchCreateRequest struct {
+	BatchID    string
+	GroupID    int64
+	UserID     int64
+	QuestionID int64
+	Members    []int64
+}
+
+// ChatGroupBatchMemberReply 描述批次内一个成员的回复，用于汇总模型生成总结
+type ChatGroupBatchMemberReply struct {
+	MemberID int64
+	Content  string
+}
+
+// ChatGroupBatchStatusView 是 GroupChatBatchStatus 接口返回的进度视图
+type ChatGroupBatchStatusView struct {
+	BatchID string                               `json:"batch_id"`
+	Done    bool                                 `json:"done"`
+	Members map[int64]ChatGroupBatchMemberStatus `json:"members"`
+	Summary string                               `json:"summary,omitempty"`
+}
+
+// ChatGroupBatchRepo 负责批量群聊任务整体进度的读写：每个批次在 chat_group_batches
+// 中有一条主记录，每个成员在 chat_group_batch_members 中有一条子记录，协调任务与各
+// 成员子任务分别并发读写这两张表来汇报/查询整体进度
+type ChatGroupBatchRepo struct {
+	db *sql.DB
+}
+
+// Create 创建一条批次主记录及其下属的成员记录，均初始化为 pending 状态
+func (r *ChatGroupBatchRepo) Create(ctx context.Context, req ChatGroupBatchCreateRequest) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO chat_group_batches (batch_id, group_id, user_id, question_id, created_at) VALUES (?, ?, ?, ?, NOW())`,
+		req.BatchID, req.GroupID, req.UserID, req.QuestionID,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, memberID := range req.Members {
+		if _, err := r.db.ExecContext(
+			ctx,
+			`INSERT INTO chat_group_batch_members (batch_id, member_id, status, created_at) VALUES (?, ?, ?, NOW())`,
+			req.BatchID, memberID, ChatGroupBatchMemberPending,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MarkMemberRunning 将批次内指定成员从 pending 转为 running，在子任务被 worker 取出、
+// 真正开始生成回复时调用，使轮询批次状态的客户端能区分"仍在排队"与"正在生成中"
+func (r *ChatGroupBatchRepo) MarkMemberRunning(ctx context.Context, batchID string, memberID int64) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		`UPDATE chat_group_batch_members SET status=?, updated_at=NOW() WHERE batch_id=? AND member_id=? AND status=?`,
+		ChatGroupBatchMemberRunning, batchID, memberID, ChatGroupBatchMemberPending,
+	)
+	return err
+}
+
+// MarkMemberFailed 将批次内指定成员标记为失败
+func (r *ChatGroupBatchRepo) MarkMemberFailed(ctx context.Context, batchID string, memberID int64, reason string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		`UPDATE chat_group_batch_members SET status=?, error=?, updated_at=NOW() WHERE batch_id=? AND member_id=?`,
+		ChatGroupBatchMemberFailed, reason, batchID, memberID,
+	)
+	return err
+}
+
+// MarkMemberSucceed 将批次内指定成员标记为成功，并记录其对应的群聊消息 ID
+func (r *ChatGroupBatchRepo) MarkMemberSucceed(ctx context.Context, batchID string, memberID int64, messageID int64) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		`UPDATE chat_group_batch_members SET status=?, message_id=?, updated_at=NOW() WHERE batch_id=? AND member_id=?`,
+		ChatGroupBatchMemberSucceed, messageID, batchID, memberID,
+	)
+	return err
+}
+
+// IsDone 判断批次内的所有成员是否都已经产生最终结果（成功或失败），仍在排队（pending）
+// 或生成中（running）的成员都算作未完成
+func (r *ChatGroupBatchRepo) IsDone(ctx context.Context, batchID string) (bool, error) {
+	var unfinished int
+	if err := r.db.QueryRowContext(
+		ctx,
+		`SELECT COUNT(*) FROM chat_group_batch_members WHERE batch_id=? AND status IN (?, ?)`,
+		batchID, ChatGroupBatchMemberPending, ChatGroupBatchMemberRunning,
+	).Scan(&unfinished); err != nil {
+		return false, err
+	}
+	return unfinished == 0, nil
+}
+
+// MemberReplies 返回批次内所有已成功成员对应群聊消息的内容，供汇总模型使用
+func (r *ChatGroupBatchRepo) MemberReplies(ctx context.Context, batchID string) ([]ChatGroupBatchMemberReply, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT m.member_id, g.message
+		 FROM chat_group_batch_members m
+		 JOIN chat_group_messages g ON g.id = m.message_id
+		 WHERE m.batch_id=? AND m.status=?`,
+		batchID, ChatGroupBatchMemberSucceed,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var replies []ChatGroupBatchMemberReply
+	for rows.Next() {
+		var reply ChatGroupBatchMemberReply
+		if err := rows.Scan(&reply.MemberID, &reply.Content); err != nil {
+			return nil, err
+		}
+		replies = append(replies, reply)
+	}
+
+	return replies, rows.Err()
+}
+
+// WriteSummary 将汇总模型生成的总结写回批次主记录
+func (r *ChatGroupBatchRepo) WriteSummary(ctx context.Context, batchID string, groupID, userID int64, summary string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		`UPDATE chat_group_batches SET summary=?, updated_at=NOW() WHERE batch_id=? AND group_id=? AND user_id=?`,
+		summary, batchID, groupID, userID,
+	)
+	return err
+}
+
+// Status 返回批次当前的整体进度，供 GroupChatBatchStatus 接口展示
+func (r *ChatGroupBatchRepo) Status(ctx context.Context, batchID string) (ChatGroupBatchStatusView, error) {
+	view := ChatGroupBatchStatusView{BatchID: batchID, Members: map[int64]ChatGroupBatchMemberStatus{}}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT member_id, status FROM chat_group_batch_members WHERE batch_id=?`, batchID)
+	if err != nil {
+		return view, err
+	}
+	defer rows.Close()
+
+	done := true
+	for rows.Next() {
+		var memberID int64
+		var status ChatGroupBatchMemberStatus
+		if err := rows.Scan(&memberID, &status); err != nil {
+			return view, err
+		}
+		view.Members[memberID] = status
+		if chatGroupBatchMemberUnfinished(status) {
+			done = false
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return view, err
+	}
+	view.Done = done
+
+	var summary sql.NullString
+	if err := r.db.QueryRowContext(ctx, `SELECT summary FROM chat_group_batches WHERE batch_id=?`, batchID).Scan(&summary); err != nil && err != sql.ErrNoRows {
+		return view, err
+	}
+	view.Summary = summary.String
+
+	return view, nil
+}
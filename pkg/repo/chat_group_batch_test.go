@@ -0,0 +1,18 @@
+package repo
+
+import "testing"
+
+func TestChatGroupBatchMemberUnfinished(t *testing.T) {
+	cases := map[ChatGroupBatchMemberStatus]bool{
+		ChatGroupBatchMemberPending: true,
+		ChatGroupBatchMemberRunning: true,
+		ChatGroupBatchMemberSucceed: false,
+		ChatGroupBatchMemberFailed:  false,
+	}
+
+	for status, want := range cases {
+		if got := chatGroupBatchMemberUnfinished(status); got != want {
+			t.Fatalf("chatGroupBatchMemberUnfinished(%q) = %v, want %v", status, got, want)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/mylxsw/aidea-server/internal/coins"
+)
+
+// Repository 聚合了各个子领域的数据访问对象，所有队列任务通过它访问数据库
+type Repository struct {
+	db *sql.DB
+
+	ChatGroup      *ChatGroupRepo
+	ChatGroupBatch *ChatGroupBatchRepo
+	Queue          *QueueRepo
+	Quota          *QuotaRepo
+}
+
+// NewRepository 创建一个 Repository 实例
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{
+		db:             db,
+		ChatGroup:      &ChatGroupRepo{db: db},
+		ChatGroupBatch: &ChatGroupBatchRepo{db: db},
+		Queue:          &QueueRepo{db: db},
+		Quota:          &QuotaRepo{db: db},
+	}
+}
+
+// dbExecutor 是 *sql.DB 与 *sql.Tx 的公共子集，子仓储通过它访问数据库，既可以直接打到
+// 连接池上，也可以在 Transaction 内绑定到同一个 *sql.Tx 上
+type dbExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// txKey 是 Transaction 绑定的 *sql.Tx 在 context 中的存储键
+type txKey struct{}
+
+// executor 返回 ctx 内绑定的事务，不存在时回退到连接池 fallback。ChatGroupRepo.UpdateChatMessage、
+// QueueRepo.Update 与 QuotaRepo.QuotaConsume 通过它取得真正要执行语句的对象，这样同一个
+// Transaction 回调里对它们的多次调用会落在同一个 *sql.Tx 上，而脱离 Transaction 之外的调用
+// 仍然直接使用连接池；ChatGroupRepo.CreateMessage 与 ChatGroupBatchRepo 的方法不经过它，
+// 总是直接使用连接池
+func executor(ctx context.Context, fallback dbExecutor) dbExecutor {
+	if tx, ok := ctx.Value(txKey{}).(dbExecutor); ok {
+		return tx
+	}
+	return fallback
+}
+
+// Transaction 在单个数据库事务中执行 fn，fn 返回 error 时整个事务回滚。fn 内对
+// 经过 executor 的方法（见 executor）的调用只要复用传入的 ctx，就会自动落在
+// 同一个事务上，而不是各自打到连接池的不同连接上
+func (r *Repository) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	txCtx := context.WithValue(ctx, txKey{}, tx)
+	if err := fn(txCtx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ModelStatus 描述一个模型当前是否可用
+type ModelStatus string
+
+const (
+	ModelStatusEnabled  ModelStatus = "enabled"
+	ModelStatusDisabled ModelStatus = "disabled"
+)
+
+// Model 是模型的基础信息，ToCoinModel 用于转换为计费所需的结构
+type Model struct {
+	ModelId string
+	Status  ModelStatus
+
+	InputPricePer1K  int64
+	OutputPricePer1K int64
+}
+
+// ToCoinModel 将模型的计费信息转换为 internal/coins 计费所需的结构
+func (m Model) ToCoinModel() coins.CoinModel {
+	return coins.CoinModel{
+		ModelId:          m.ModelId,
+		InputPricePer1K:  m.InputPricePer1K,
+		OutputPricePer1K: m.OutputPricePer1K,
+	}
+}
+
+// MessageStatus 描述群聊消息当前所处的状态
+type MessageStatus string
+
+const (
+	MessageStatusInProgress MessageStatus = "in_progress"
+	MessageStatusSucceed    MessageStatus = "succeed"
+	MessageStatusFailed     MessageStatus = "failed"
+)
+
+// QuotaSource 描述一条群聊消息的配额来源，用于前端展示“为什么这条消息是免费的”
+type QuotaSource string
+
+const (
+	QuotaSourceMonthlyFree QuotaSource = "monthly_free"
+	QuotaSourceDailyFree   QuotaSource = "daily_free"
+	QuotaSourcePaid        QuotaSource = "paid"
+)
+
+// ChatGroupMessageUpdate 描述对一条群聊消息状态的一次更新
+type ChatGroupMessageUpdate struct {
+	Message       string
+	Error         string
+	Status        MessageStatus
+	TokenConsumed int64
+	QuotaConsumed int64
+
+	// QuotaSource 本次消息的计费来源（每月免费/每日免费/按量计费）
+	QuotaSource QuotaSource
+	// ActualModelID 实际处理该消息的模型 ID，发生故障转移时与请求的模型不同
+	ActualModelID string
+	// FallbackReason 发生了模型故障转移时的原因说明，未发生转移时为空
+	FallbackReason string
+}
+
+// ChatGroupRepo 负责群聊消息的读写
+type ChatGroupRepo struct {
+	db *sql.DB
+}
+
+// CreateMessage 为某个群成员创建一条待处理的群聊消息，返回新消息的 ID。在批量群聊
+// 场景下，调用方需要在把任务交给队列之前先拿到这个 ID，这样每个成员的任务才有独立的
+// 消息行可以落库，而不是共用同一个占位 ID
+func (r *ChatGroupRepo) CreateMessage(ctx context.Context, groupID, userID, memberID, questionID int64, modelID string) (int64, error) {
+	res, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO chat_group_messages (group_id, user_id, member_id, question_id, model_id, status, created_at) VALUES (?, ?, ?, ?, ?, ?, NOW())`,
+		groupID, userID, memberID, questionID, modelID, MessageStatusInProgress,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// UpdateChatMessage 更新一条群聊消息的状态。在 Repository.Transaction 回调内调用时，
+// 会落在回调绑定的同一个 *sql.Tx 上，而不是连接池的其他连接
+func (r *ChatGroupRepo) UpdateChatMessage(ctx context.Context, groupID, userID, messageID int64, update ChatGroupMessageUpdate) error {
+	_, err := executor(ctx, r.db).ExecContext(
+		ctx,
+		`UPDATE chat_group_messages SET message=?, error=?, status=?, token_consumed=?, quota_consumed=?, quota_source=?, actual_model_id=?, fallback_reason=?, updated_at=NOW()
+		 WHERE group_id=? AND user_id=? AND id=?`,
+		update.Message, update.Error, update.Status, update.TokenConsumed, update.QuotaConsumed, update.QuotaSource, update.ActualModelID, update.FallbackReason,
+		groupID, userID, messageID,
+	)
+	return err
+}
+
+// QueueTaskStatus 描述一个异步任务当前的执行状态
+type QueueTaskStatus string
+
+const (
+	QueueTaskStatusSuccess QueueTaskStatus = "success"
+	QueueTaskStatusFailed  QueueTaskStatus = "failed"
+)
+
+// QueueRepo 负责异步任务执行状态的读写
+type QueueRepo struct {
+	db *sql.DB
+}
+
+// Update 更新指定任务的执行状态及结果。在 Repository.Transaction 回调内调用时，
+// 会落在回调绑定的同一个 *sql.Tx 上，而不是连接池的其他连接
+func (r *QueueRepo) Update(ctx context.Context, taskID string, status QueueTaskStatus, result any) error {
+	_, err := executor(ctx, r.db).ExecContext(ctx, `UPDATE queue_tasks SET status=?, result=?, updated_at=NOW() WHERE id=?`, status, result, taskID)
+	return err
+}
+
+// QuotaUsedMeta 记录一次配额消耗的来源信息，用于账单明细展示
+type QuotaUsedMeta struct {
+	Source string
+	Model  string
+}
+
+// NewQuotaUsedMeta 创建一条配额消耗的来源信息
+func NewQuotaUsedMeta(source, model string) QuotaUsedMeta {
+	return QuotaUsedMeta{Source: source, Model: model}
+}
+
+// QuotaRepo 负责用户智慧果配额的扣减与查询
+type QuotaRepo struct {
+	db *sql.DB
+}
+
+// QuotaConsume 扣减用户的智慧果配额。在 Repository.Transaction 回调内调用时，
+// 会落在回调绑定的同一个 *sql.Tx 上，而不是连接池的其他连接
+func (r *QuotaRepo) QuotaConsume(ctx context.Context, userID int64, quota int64, meta QuotaUsedMeta) error {
+	_, err := executor(ctx, r.db).ExecContext(
+		ctx,
+		`INSERT INTO quota_used_logs (user_id, quota, source, model, created_at) VALUES (?, ?, ?, ?, NOW())`,
+		userID, quota, meta.Source, meta.Model,
+	)
+	return err
+}
@@ -0,0 +1,47 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+// fakeExecutor is a minimal dbExecutor stand-in used to verify that Transaction
+// threads its *sql.Tx through context instead of each repo method closing over
+// the connection pool directly.
+type fakeExecutor struct {
+	name string
+}
+
+func (f *fakeExecutor) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return nil, nil
+}
+
+func (f *fakeExecutor) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return nil, nil
+}
+
+func (f *fakeExecutor) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return nil
+}
+
+func TestExecutorFallsBackToPoolOutsideTransaction(t *testing.T) {
+	pool := &fakeExecutor{name: "pool"}
+
+	got := executor(context.Background(), pool)
+	if got != dbExecutor(pool) {
+		t.Fatalf("executor() should fall back to the pool when no transaction is bound to ctx")
+	}
+}
+
+func TestExecutorUsesBoundTransaction(t *testing.T) {
+	pool := &fakeExecutor{name: "pool"}
+	tx := &fakeExecutor{name: "tx"}
+
+	ctx := context.WithValue(context.Background(), txKey{}, dbExecutor(tx))
+
+	got := executor(ctx, pool)
+	if got != dbExecutor(tx) {
+		t.Fatalf("executor() should return the transaction bound to ctx, not the pool fallback")
+	}
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/mylxsw/aidea-server/config"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+)
+
+// Service 聚合了各个业务领域的服务对象
+type Service struct {
+	Chat *ChatService
+	User *UserService
+}
+
+// NewService 创建一个 Service 实例
+func NewService(conf *config.Config, db *sql.DB, rep *repo.Repository) *Service {
+	return &Service{
+		Chat: &ChatService{db: db, rep: rep},
+		User: &UserService{db: db, conf: conf},
+	}
+}
+
+// ChatService 提供与对话模型相关的业务逻辑
+type ChatService struct {
+	db  *sql.DB
+	rep *repo.Repository
+}
+
+// Model 返回指定 ID 的模型信息，不存在时返回 nil
+func (s *ChatService) Model(ctx context.Context, modelID string) *repo.Model {
+	var mod repo.Model
+	row := s.db.QueryRowContext(ctx, `SELECT model_id, status, input_price_per_1k, output_price_per_1k FROM chat_models WHERE model_id=?`, modelID)
+	if err := row.Scan(&mod.ModelId, &mod.Status, &mod.InputPricePer1K, &mod.OutputPricePer1K); err != nil {
+		return nil
+	}
+	return &mod
+}
+
+// FreeChatRequestCounts 返回用户在指定模型上剩余的每月免费调用次数
+func (s *ChatService) FreeChatRequestCounts(ctx context.Context, userID int64, modelID string) (int64, error) {
+	var left int64
+	row := s.db.QueryRowContext(ctx, `SELECT left_count FROM free_chat_request_counts WHERE user_id=? AND model_id=?`, userID, modelID)
+	if err := row.Scan(&left); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return left, nil
+}
+
+// UpdateFreeChatCount 消耗用户在指定模型上的一次每月免费调用次数
+func (s *ChatService) UpdateFreeChatCount(ctx context.Context, userID int64, modelID string) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`UPDATE free_chat_request_counts SET left_count = left_count - 1 WHERE user_id=? AND model_id=? AND left_count > 0`,
+		userID, modelID,
+	)
+	return err
+}
+
+// FallbackChain 返回指定模型的故障转移链：当该模型不可用时，按顺序尝试的替代模型列表，
+// 配置保存在 model_fallback_chains 表中，每个模型都可以配置独立的转移链
+func (s *ChatService) FallbackChain(ctx context.Context, modelID string) []string {
+	rows, err := s.db.QueryContext(ctx, `SELECT fallback_model_id FROM model_fallback_chains WHERE model_id=? ORDER BY priority ASC`, modelID)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var chain []string
+	for rows.Next() {
+		var fallbackModelID string
+		if err := rows.Scan(&fallbackModelID); err != nil {
+			continue
+		}
+		chain = append(chain, fallbackModelID)
+	}
+	return chain
+}
+
+// UserService 提供与用户账户相关的业务逻辑
+type UserService struct {
+	db   *sql.DB
+	conf *config.Config
+}
+
+// UnfreezeUserQuota 释放用户此前被冻结的智慧果
+func (s *UserService) UnfreezeUserQuota(ctx context.Context, userID int64, quota int64) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE users SET frozen_quota = frozen_quota - ? WHERE id=?`, quota, userID)
+	return err
+}
+
+// DailyGroupChatAllowance 返回用户在指定模型上每日的群聊免费调用次数额度：优先读取
+// user_daily_limits 表中针对该用户的覆盖值，不存在覆盖时回退到全局默认配置
+func (s *UserService) DailyGroupChatAllowance(ctx context.Context, userID int64, modelID string) (int64, error) {
+	var allowance int64
+	row := s.db.QueryRowContext(ctx, `SELECT daily_allowance FROM user_daily_limits WHERE user_id=? AND model_id=?`, userID, modelID)
+	if err := row.Scan(&allowance); err != nil {
+		if err == sql.ErrNoRows {
+			return s.conf.GroupChatDailyFreeAllowance, nil
+		}
+		return 0, err
+	}
+	return allowance, nil
+}
+
+// SetDailyGroupChatAllowance 批量覆盖一组用户在指定模型上的每日群聊免费额度，供管理端使用
+func (s *UserService) SetDailyGroupChatAllowance(ctx context.Context, userIDs []int64, modelID string, allowance int64) error {
+	for _, userID := range userIDs {
+		_, err := s.db.ExecContext(
+			ctx,
+			`INSERT INTO user_daily_limits (user_id, model_id, daily_allowance) VALUES (?, ?, ?)
+			 ON DUPLICATE KEY UPDATE daily_allowance=VALUES(daily_allowance)`,
+			userID, modelID, allowance,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Timezone 返回用户所在的时区，未设置时回退到服务器本地时区
+func (s *UserService) Timezone(ctx context.Context, userID int64) (*time.Location, error) {
+	var tz string
+	row := s.db.QueryRowContext(ctx, `SELECT timezone FROM users WHERE id=?`, userID)
+	if err := row.Scan(&tz); err != nil || tz == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return time.Local, nil
+	}
+	return loc, nil
+}